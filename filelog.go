@@ -3,29 +3,104 @@
 package log4go
 
 import (
-	"archive/tar"
 	"compress/gzip"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// MuxWriter guards an *os.File with a mutex so that writes from the logging
+// goroutine and housekeeping (rotation, pruning, compression) can never race
+// on the same fd.
+type MuxWriter struct {
+	mu sync.Mutex
+	fd *os.File
+}
+
+// Write implements io.Writer.
+func (m *MuxWriter) Write(b []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.fd == nil {
+		return 0, errors.New("MuxWriter: no file open")
+	}
+	return m.fd.Write(b)
+}
+
+// SetFile swaps in a new underlying file and returns the previous one, if
+// any, so the caller can close it once it's safely detached.
+func (m *MuxWriter) SetFile(fd *os.File) (old *os.File) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	old, m.fd = m.fd, fd
+	return old
+}
+
+// HasFile reports whether a file is currently open.
+func (m *MuxWriter) HasFile() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.fd != nil
+}
+
+// Sync flushes the underlying file to disk.
+func (m *MuxWriter) Sync() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.fd == nil {
+		return nil
+	}
+	return m.fd.Sync()
+}
+
+// Close closes the underlying file.
+func (m *MuxWriter) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.fd == nil {
+		return nil
+	}
+	return m.fd.Close()
+}
+
 // This log writer sends output to a file
 type FileLogWriter struct {
-	rec chan *LogRecord
-	rot chan bool
+	rec  chan *LogRecord
+	rot  chan bool
+	done chan struct{}
+
+	// mill signals the single mill goroutine that there's housekeeping
+	// (compression, pruning) to do; millDone closes once that goroutine has
+	// drained mill and returned. See requestMill/millRun.
+	mill     chan bool
+	millDone chan struct{}
 
 	// The opened file
 	filename string
-	file     *os.File
+	out      *MuxWriter
+
+	// Block LogWrite when rec is full instead of dropping the record
+	blockOnFull bool
+	dropped     uint64
 
 	// The logging format
 	format string
 
+	// useJSON makes the write loop render each record as an escaped JSON
+	// object (see formatJSON) instead of substituting it into format
+	useJSON bool
+
 	// File header/trailer
 	header, trailer string
 
@@ -41,43 +116,204 @@ type FileLogWriter struct {
 	daily          bool
 	daily_opendate int
 
+	// Rotate hourly
+	hourly          bool
+	hourly_opendate int
+	hourly_opentime int
+
+	// Rotate at a fixed interval (e.g. every 15 minutes), regardless of the
+	// daily/hourly wall-clock boundary
+	rotateInterval time.Duration
+	lastRotate     time.Time
+
 	// Keep old logfiles (.001, .002, etc)
 	rotate    bool
 	maxbackup int
 	logindex  int
 	//compresslog []string
+
+	// Maximum age of backups, in days; 0 means backups are never pruned by age
+	maxage int
+
+	// gzip-compress rotated backups in place
+	compress bool
+
+	// Use local time instead of UTC when formatting backup file names
+	localtime bool
+
+	// Decides when to rotate and how rotated backups are named
+	policy RotationPolicy
+}
+
+// backupTimeFormat is the layout used for the timestamp embedded in rotated
+// backup file names, e.g. app-2006-01-02T15-04-05-001.log for the active
+// file app.log
+const backupTimeFormat = "2006-01-02T15-04-05"
+
+// defaultNextName is the NextName implementation shared by the built-in
+// policies: it appends a timestamp and the writer's rotation index to base.
+// The index is what disambiguates two rotations landing in the same wall-clock
+// second (trivially reachable under size-based rotation, or a sub-second
+// SetRotateInterval) - without it they'd produce the same backupName and
+// os.Rename would silently overwrite the earlier backup.
+func defaultNextName(base string, now time.Time, index int) string {
+	return fmt.Sprintf("%s-%s-%03d.log", base, now.Format(backupTimeFormat), index)
+}
+
+// RotationPolicy decides when a FileLogWriter should rotate and what the
+// resulting backup file should be named. The built-in policies below cover
+// line count, size, daily, hourly, and interval triggers; CompositePolicy ORs
+// any number of them together. Custom policies (e.g. rotate on SIGHUP, or at
+// a fixed clock time) can be plugged in with SetRotationPolicy without
+// forking the writer.
+type RotationPolicy interface {
+	// ShouldRotate reports whether w should rotate before rec is written.
+	ShouldRotate(w *FileLogWriter, rec *LogRecord) bool
+
+	// NextName returns the backup name for the file being rotated out, given
+	// the writer's base filename, the current time, and the writer's
+	// monotonically increasing rotation index.
+	NextName(base string, now time.Time, index int) string
+}
+
+// LineCountPolicy rotates once the line count set by SetRotateLines is reached.
+type LineCountPolicy struct{}
+
+func (LineCountPolicy) ShouldRotate(w *FileLogWriter, rec *LogRecord) bool {
+	return w.maxlines > 0 && w.maxlines_curlines >= w.maxlines
+}
+
+func (LineCountPolicy) NextName(base string, now time.Time, index int) string {
+	return defaultNextName(base, now, index)
+}
+
+// SizeLimitPolicy rotates once the byte count set by SetRotateSize is reached.
+type SizeLimitPolicy struct{}
+
+func (SizeLimitPolicy) ShouldRotate(w *FileLogWriter, rec *LogRecord) bool {
+	return w.maxsize > 0 && w.maxsize_cursize >= w.maxsize
+}
+
+func (SizeLimitPolicy) NextName(base string, now time.Time, index int) string {
+	return defaultNextName(base, now, index)
+}
+
+// DailyPolicy rotates the first time a log record is written after midnight,
+// when SetRotateDaily(true) is set.
+type DailyPolicy struct{}
+
+func (DailyPolicy) ShouldRotate(w *FileLogWriter, rec *LogRecord) bool {
+	return w.daily && time.Now().Day() != w.daily_opendate
+}
+
+func (DailyPolicy) NextName(base string, now time.Time, index int) string {
+	return defaultNextName(base, now, index)
+}
+
+// HourlyPolicy rotates the first time a log record is written after the top
+// of the hour, when SetRotateHourly(true) is set.
+type HourlyPolicy struct{}
+
+func (HourlyPolicy) ShouldRotate(w *FileLogWriter, rec *LogRecord) bool {
+	now := time.Now()
+	return w.hourly && (now.Day() != w.hourly_opendate || now.Hour() != w.hourly_opentime)
+}
+
+func (HourlyPolicy) NextName(base string, now time.Time, index int) string {
+	return defaultNextName(base, now, index)
+}
+
+// IntervalPolicy rotates once the duration set by SetRotateInterval has
+// elapsed since the writer last rotated.
+type IntervalPolicy struct{}
+
+func (IntervalPolicy) ShouldRotate(w *FileLogWriter, rec *LogRecord) bool {
+	return w.rotateInterval > 0 && !w.lastRotate.IsZero() && time.Now().Sub(w.lastRotate) >= w.rotateInterval
+}
+
+func (IntervalPolicy) NextName(base string, now time.Time, index int) string {
+	return defaultNextName(base, now, index)
+}
+
+// CompositePolicy rotates when any of its Policies says to. Naming is left to
+// defaultNextName; Policies with a custom NextName should be used standalone
+// via SetRotationPolicy instead of through a CompositePolicy.
+type CompositePolicy struct {
+	Policies []RotationPolicy
+}
+
+func (c CompositePolicy) ShouldRotate(w *FileLogWriter, rec *LogRecord) bool {
+	for _, p := range c.Policies {
+		if p.ShouldRotate(w, rec) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c CompositePolicy) NextName(base string, now time.Time, index int) string {
+	return defaultNextName(base, now, index)
 }
 
 // This is the FileLogWriter's output method
 func (w *FileLogWriter) LogWrite(rec *LogRecord) {
-	w.rec <- rec
+	if w.blockOnFull {
+		w.rec <- rec
+		return
+	}
+	select {
+	case w.rec <- rec:
+	default:
+		atomic.AddUint64(&w.dropped, 1)
+	}
+}
+
+// Dropped returns the number of log records dropped because the internal
+// buffer was full while SetBlockOnFull(false) was set.
+func (w *FileLogWriter) Dropped() uint64 {
+	return atomic.LoadUint64(&w.dropped)
 }
 
+// Close blocks until the trailer has been written, the log file has been
+// fsynced and closed, and any in-flight backup compression/pruning has
+// finished.
 func (w *FileLogWriter) Close() {
 	close(w.rec)
-	w.file.Sync()
+	<-w.done
+	<-w.millDone
 }
 
 // NewFileLogWriter creates a new LogWriter which writes to the given file and
 // has rotation enabled if rotate is true.
 //
 // If rotate is true, any time a new log file is opened, the old one is renamed
-// with a .### extension to preserve it.  The various Set* methods can be used
-// to configure log rotation based on lines, size, and daily.
+// with a timestamp suffix to preserve it.  The various Set* methods can be used
+// to configure log rotation based on lines, size, daily, hourly, or a fixed
+// interval (SetRotateInterval).
 //
 // The standard log-line format is:
 //   [%D %T] [%L] (%S) %M
 func NewFileLogWriter(fname string, rotate bool) *FileLogWriter {
 	w := &FileLogWriter{
-		rec:            make(chan *LogRecord, LogBufferLength),
-		rot:            make(chan bool),
-		filename:       fname,
-		format:         "[%D %T] [%L] (%S) %M",
-		daily_opendate: -1,
-		rotate:         rotate,
-		maxbackup:      999,
-		logindex:       0,
+		rec:             make(chan *LogRecord, LogBufferLength),
+		rot:             make(chan bool),
+		done:            make(chan struct{}),
+		mill:            make(chan bool, 1),
+		millDone:        make(chan struct{}),
+		filename:        fname,
+		out:             new(MuxWriter),
+		format:          "[%D %T] [%L] (%S) %M",
+		daily_opendate:  -1,
+		hourly_opendate: -1,
+		hourly_opentime: -1,
+		rotate:          rotate,
+		maxbackup:       999,
+		logindex:        recoverLogIndex(fname),
+		blockOnFull:     true,
 	}
+	w.policy = CompositePolicy{Policies: []RotationPolicy{
+		LineCountPolicy{}, SizeLimitPolicy{}, DailyPolicy{}, HourlyPolicy{}, IntervalPolicy{},
+	}}
 
 	// open the file for the first time
 	if err := w.intRotate(); err != nil {
@@ -85,12 +321,15 @@ func NewFileLogWriter(fname string, rotate bool) *FileLogWriter {
 		return nil
 	}
 
+	go w.millRun()
+
 	go func() {
 		defer func() {
-			if w.file != nil {
-				fmt.Fprint(w.file, FormatLogRecord(w.trailer, &LogRecord{Created: time.Now()}))
-				w.file.Close()
-			}
+			fmt.Fprint(w.out, FormatLogRecord(w.trailer, &LogRecord{Created: time.Now()}))
+			w.out.Sync()
+			w.out.Close()
+			close(w.mill)
+			close(w.done)
 		}()
 
 		for {
@@ -104,10 +343,7 @@ func NewFileLogWriter(fname string, rotate bool) *FileLogWriter {
 				if !ok {
 					return
 				}
-				now := time.Now()
-				if (w.maxlines > 0 && w.maxlines_curlines >= w.maxlines) ||
-					(w.maxsize > 0 && w.maxsize_cursize >= w.maxsize) ||
-					(w.daily && now.Day() != w.daily_opendate) {
+				if w.policy.ShouldRotate(w, rec) {
 					if err := w.intRotate(); err != nil {
 						fmt.Fprintf(os.Stderr, "FileLogWriter(%q): %s\n", w.filename, err)
 						continue
@@ -115,7 +351,13 @@ func NewFileLogWriter(fname string, rotate bool) *FileLogWriter {
 				}
 
 				// Perform the write
-				n, err := fmt.Fprint(w.file, FormatLogRecord(w.format, rec))
+				var line string
+				if w.useJSON {
+					line = w.formatJSON(rec)
+				} else {
+					line = FormatLogRecord(w.format, rec)
+				}
+				n, err := fmt.Fprint(w.out, line)
 				if err != nil {
 					fmt.Fprintf(os.Stderr, "FileLogWriter(%q): %s\n", w.filename, err)
 					continue
@@ -138,58 +380,47 @@ func (w *FileLogWriter) Rotate() {
 
 // If this is called in a threaded context, it MUST be synchronized
 func (w *FileLogWriter) intRotate() error {
-	// Close any log file that may be open
-	if w.file != nil {
-		fmt.Fprint(w.file, FormatLogRecord(w.trailer, &LogRecord{Created: time.Now()}))
-		w.file.Close()
+	// Write the trailer to any log file that may be open; the fd itself is
+	// closed below, once the replacement is safely swapped in via out.SetFile
+	if w.out.HasFile() {
+		fmt.Fprint(w.out, FormatLogRecord(w.trailer, &LogRecord{Created: time.Now()}))
 	}
 
 	now := time.Now()
-	if (w.daily_opendate != -1) && (now.Day() != w.daily_opendate) {
-		//获取日志目录
-		index := strings.LastIndex(w.filename, string(os.PathSeparator))
-		if index != -1 {
-			dir := w.filename[:index+1]
-			fmt.Fprintf(os.Stderr, "tarLogFile %v %v\n", w.filename, dir)
-			//遍历当前目录下的所有文件
-			// 获取 dir 下的文件或子目录列表
-			fis, er := ioutil.ReadDir(dir)
-			if er == nil {
-				var files []string
-				// 开始遍历
-				for _, fi := range fis {
-					if !fi.IsDir() {
-						files = append(files, dir+fi.Name())
-					}
-				}
-				go w.tarLogFile(files, dir)
-			} else {
-				fmt.Fprintf(os.Stderr, "read dir:%s failed,%s\n", dir, er.Error())
+	activeName := w.filename + ".log"
+
+	// If we are keeping log files, move the active file out of the way
+	// (lumberjack-style) before opening a fresh one, so a backup's embedded
+	// timestamp records exactly when it stopped being written to
+	if w.rotate {
+		if _, err := os.Stat(activeName); err == nil {
+			w.logindex++
+			backupName := w.policy.NextName(w.filename, w.backupTime(now), w.logindex)
+			if err := os.Rename(activeName, backupName); err != nil {
+				fmt.Fprintf(os.Stderr, "rename %q to %q failed: %s\n", activeName, backupName, err.Error())
+				return err
 			}
-		} else {
-			fmt.Fprintf(os.Stderr, "w.filename:%s failed\n", w.filename)
+			w.requestMill()
 		}
 	}
-	nfilename := w.filename
 
-	// If we are keeping log files, move it to the next available number
-	if w.rotate {
-		w.logindex++
-		nfilename = w.filename + fmt.Sprintf("_%03d", w.logindex)
-	}
-	nfilename = nfilename + ".log"
 	// Open the log file
-	fd, err := os.OpenFile(nfilename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0664)
+	fd, err := os.OpenFile(activeName, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0664)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "OpenFile failed: %s\n", err.Error())
 		return err
 	}
-	w.file = fd
+	if old := w.out.SetFile(fd); old != nil {
+		old.Close()
+	}
 
-	fmt.Fprint(w.file, FormatLogRecord(w.header, &LogRecord{Created: now}))
+	fmt.Fprint(w.out, FormatLogRecord(w.header, &LogRecord{Created: now}))
 
-	// Set the daily open date to the current date
+	// Set the daily/hourly open date to the current date/hour
 	w.daily_opendate = now.Day()
+	w.hourly_opendate = now.Day()
+	w.hourly_opentime = now.Hour()
+	w.lastRotate = now
 
 	// initialize rotation values
 	w.maxlines_curlines = 0
@@ -198,6 +429,252 @@ func (w *FileLogWriter) intRotate() error {
 	return nil
 }
 
+// backupTime returns t in the locale backup file names should be formatted
+// in, honoring SetLocalTime.
+func (w *FileLogWriter) backupTime(t time.Time) time.Time {
+	if w.localtime {
+		return t.Local()
+	}
+	return t.UTC()
+}
+
+// requestMill wakes the mill goroutine to compress and prune backups.
+// Rotations that land while mill is already running collapse into the run
+// that's about to start - mill always rescans the directory, so nothing is
+// missed - which is why the send is non-blocking.
+func (w *FileLogWriter) requestMill() {
+	select {
+	case w.mill <- true:
+	default:
+	}
+}
+
+// millRun is the single goroutine that performs all housekeeping (gzip
+// compression and pruning) for this writer. Running it here, instead of one
+// goroutine per rotation, keeps compressBackups and pruneBackups from ever
+// running concurrently with each other: they run one after the other here,
+// so a prune can never race a sibling rotation's compress for the same
+// backup.
+func (w *FileLogWriter) millRun() {
+	defer close(w.millDone)
+	for range w.mill {
+		if w.compress {
+			if err := w.compressBackups(); err != nil {
+				fmt.Fprintf(os.Stderr, "compress backups for %q failed: %s\n", w.filename, err.Error())
+			}
+		}
+		w.pruneBackups()
+	}
+}
+
+// compressBackups gzips every not-yet-compressed backup for this log still on
+// disk and removes the uncompressed copy, so a backup is never eligible for
+// count-based pruning until its final, compressed form is in place.
+func (w *FileLogWriter) compressBackups() error {
+	dir, prefix := logDirAndPrefix(w.filename)
+	dir = resolveDir(dir)
+
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	pattern := backupNamePattern(prefix)
+	for _, fi := range fis {
+		if fi.IsDir() {
+			continue
+		}
+		m := pattern.FindStringSubmatch(fi.Name())
+		if m == nil || m[3] != "" { // already gzipped
+			continue
+		}
+		path := dir + string(os.PathSeparator) + fi.Name()
+		if err := gzipFile(path); err != nil {
+			fmt.Fprintf(os.Stderr, "gzip %q failed: %s\n", path, err.Error())
+			continue
+		}
+		os.Remove(path)
+	}
+	return nil
+}
+
+// logDirAndPrefix splits filename into the directory to scan for this log's
+// backups and the basename prefix those backups are named after.
+func logDirAndPrefix(filename string) (dir, prefix string) {
+	dir, prefix = ".", filename
+	if index := strings.LastIndex(filename, string(os.PathSeparator)); index != -1 {
+		dir, prefix = filename[:index], filename[index+1:]
+	}
+	return dir, prefix
+}
+
+// resolveDir follows symlinks to dir's real path; filepath.Walk/ReadDir
+// otherwise silently miss entries when the log directory itself is a
+// symlink. dir is returned unchanged if it can't be resolved.
+func resolveDir(dir string) string {
+	if real, err := filepath.EvalSymlinks(dir); err == nil {
+		return real
+	}
+	return dir
+}
+
+// backupNamePattern matches a backup produced by defaultNextName for the
+// given prefix, i.e. "<prefix>-<timestamp>-<index>.log" optionally followed
+// by ".gz", capturing the timestamp and index.
+func backupNamePattern(prefix string) *regexp.Regexp {
+	return regexp.MustCompile("^" + regexp.QuoteMeta(prefix+"-") + `(\d{4}-\d{2}-\d{2}T\d{2}-\d{2}-\d{2})-(\d+)\.log(\.gz)?$`)
+}
+
+// pruneBackups enumerates the backups for this log, sorted newest-first by
+// their embedded timestamp, and removes any beyond maxbackup count or older
+// than maxage days.
+func (w *FileLogWriter) pruneBackups() {
+	if w.maxbackup <= 0 && w.maxage <= 0 {
+		return
+	}
+
+	dir, prefix := logDirAndPrefix(w.filename)
+	dir = resolveDir(dir)
+
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "read dir:%s failed,%s\n", dir, err.Error())
+		return
+	}
+
+	// A backup can briefly exist as both "X.log" and "X.log.gz" while
+	// compressBackups is rewriting it (or if a previous run left a stray
+	// copy behind); group by timestamp+index so the pair counts as the one
+	// logical backup it is, instead of inflating the count and letting a
+	// within-window backup get pruned early.
+	type backup struct {
+		when  time.Time
+		index int
+		paths []string
+	}
+	pattern := backupNamePattern(prefix)
+	groups := make(map[string]*backup)
+	var order []string
+	for _, fi := range fis {
+		if fi.IsDir() {
+			continue
+		}
+		m := pattern.FindStringSubmatch(fi.Name())
+		if m == nil {
+			continue
+		}
+		when, err := w.parseBackupTime(m[1])
+		if err != nil {
+			continue
+		}
+		index, _ := strconv.Atoi(m[2])
+		key := m[1] + "-" + m[2]
+		b, ok := groups[key]
+		if !ok {
+			b = &backup{when: when, index: index}
+			groups[key] = b
+			order = append(order, key)
+		}
+		b.paths = append(b.paths, dir+string(os.PathSeparator)+fi.Name())
+	}
+
+	backups := make([]*backup, 0, len(order))
+	for _, key := range order {
+		backups = append(backups, groups[key])
+	}
+
+	// Newest first; ties (same second, different index) break by index so
+	// pruning order matches rotation order instead of being arbitrary.
+	sort.Slice(backups, func(i, j int) bool {
+		if !backups[i].when.Equal(backups[j].when) {
+			return backups[i].when.After(backups[j].when)
+		}
+		return backups[i].index > backups[j].index
+	})
+
+	now := time.Now()
+	maxAge := time.Duration(w.maxage) * 24 * time.Hour
+	for i, b := range backups {
+		if (w.maxbackup > 0 && i >= w.maxbackup) || (w.maxage > 0 && now.Sub(b.when) > maxAge) {
+			for _, p := range b.paths {
+				os.Remove(p)
+			}
+		}
+	}
+}
+
+// parseBackupTime parses a timestamp embedded in a backup name back into the
+// instant it denotes, using the same locale backupTime formatted it in -
+// otherwise, with SetLocalTime(true), the wall-clock digits in the name get
+// reinterpreted as UTC and maxage pruning is skewed by the zone offset.
+func (w *FileLogWriter) parseBackupTime(stamp string) (time.Time, error) {
+	if w.localtime {
+		return time.ParseInLocation(backupTimeFormat, stamp, time.Local)
+	}
+	return time.Parse(backupTimeFormat, stamp)
+}
+
+// recoverLogIndex scans filename's directory for backups already on disk -
+// both the current "<name>-<timestamp>-<index>.log[.gz]" scheme and the
+// legacy "<name>_NNN.log" one - and returns one past the highest index found,
+// so that a restarted process carries on from w.logindex instead of reusing
+// an index (and thus a name, now that defaultNextName embeds it) that's
+// already taken.
+func recoverLogIndex(filename string) int {
+	dir, prefix := logDirAndPrefix(filename)
+	dir = resolveDir(dir)
+
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return 0
+	}
+
+	legacy := regexp.MustCompile("^" + regexp.QuoteMeta(prefix) + `_(\d+)\.log$`)
+	current := backupNamePattern(prefix)
+	highest := -1
+	for _, fi := range fis {
+		if fi.IsDir() {
+			continue
+		}
+		name := fi.Name()
+		if m := legacy.FindStringSubmatch(name); m != nil {
+			if n, err := strconv.Atoi(m[1]); err == nil && n > highest {
+				highest = n
+			}
+			continue
+		}
+		if m := current.FindStringSubmatch(name); m != nil {
+			if n, err := strconv.Atoi(m[2]); err == nil && n > highest {
+				highest = n
+			}
+		}
+	}
+	return highest + 1
+}
+
+// gzipFile compresses src in place to src+".gz". The caller is responsible
+// for removing src once this returns successfully.
+func gzipFile(src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(src + ".gz")
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	gw := gzip.NewWriter(out)
+	if _, err := io.Copy(gw, in); err != nil {
+		gw.Close()
+		return err
+	}
+	return gw.Close()
+}
+
 // Set the logging format (chainable).  Must be called before the first log
 // message is written.
 func (w *FileLogWriter) SetFormat(format string) *FileLogWriter {
@@ -211,7 +688,7 @@ func (w *FileLogWriter) SetFormat(format string) *FileLogWriter {
 func (w *FileLogWriter) SetHeadFoot(head, foot string) *FileLogWriter {
 	w.header, w.trailer = head, foot
 	if w.maxlines_curlines == 0 {
-		fmt.Fprint(w.file, FormatLogRecord(w.header, &LogRecord{Created: time.Now()}))
+		fmt.Fprint(w.out, FormatLogRecord(w.header, &LogRecord{Created: time.Now()}))
 	}
 	return w
 }
@@ -240,6 +717,23 @@ func (w *FileLogWriter) SetRotateDaily(daily bool) *FileLogWriter {
 	return w
 }
 
+// Set rotate hourly (chainable). Must be called before the first log message
+// is written.
+func (w *FileLogWriter) SetRotateHourly(hourly bool) *FileLogWriter {
+	//fmt.Fprintf(os.Stderr, "FileLogWriter.SetRotateHourly: %v\n", hourly)
+	w.hourly = hourly
+	return w
+}
+
+// SetRotateInterval rotates the log file every time the given duration
+// elapses since the last rotation, independent of the daily/hourly boundary
+// checks (chainable). Pass 0 to disable interval-based rotation. Must be
+// called before the first log message is written.
+func (w *FileLogWriter) SetRotateInterval(interval time.Duration) *FileLogWriter {
+	w.rotateInterval = interval
+	return w
+}
+
 // Set max backup files. Must be called before the first log message
 // is written.
 func (w *FileLogWriter) SetRotateMaxBackup(maxbackup int) *FileLogWriter {
@@ -248,6 +742,30 @@ func (w *FileLogWriter) SetRotateMaxBackup(maxbackup int) *FileLogWriter {
 	return w
 }
 
+// SetMaxAge sets the maximum number of days to retain rotated backup files
+// (chainable). Backups older than this are pruned the next time the log
+// rotates. 0 disables age-based pruning. Must be called before the first log
+// message is written.
+func (w *FileLogWriter) SetMaxAge(days int) *FileLogWriter {
+	w.maxage = days
+	return w
+}
+
+// SetCompress enables gzip-compressing rotated backup files in place
+// (chainable). Must be called before the first log message is written.
+func (w *FileLogWriter) SetCompress(compress bool) *FileLogWriter {
+	w.compress = compress
+	return w
+}
+
+// SetLocalTime sets whether the timestamp embedded in rotated backup file
+// names is in local time instead of UTC (chainable). Must be called before
+// the first log message is written.
+func (w *FileLogWriter) SetLocalTime(localtime bool) *FileLogWriter {
+	w.localtime = localtime
+	return w
+}
+
 // SetRotate changes whether or not the old logs are kept. (chainable) Must be
 // called before the first log message is written.  If rotate is false, the
 // files are overwritten; otherwise, they are rotated to another file before the
@@ -258,106 +776,21 @@ func (w *FileLogWriter) SetRotate(rotate bool) *FileLogWriter {
 	return w
 }
 
-func (w *FileLogWriter) tarLogFile(files []string, dir string) {
-	fmt.Fprintf(os.Stderr, "tarLogFile %v \n", w.filename)
-	os.Mkdir(dir+"backup", os.ModePerm) //在当前目录下生成md目录
-
-	destfile := dir + "backup" + string(os.PathSeparator) + time.Now().AddDate(0, 0, -1).Format("2006-01-02-15-04") + ".tar.gz"
-	fmt.Fprintf(os.Stderr, "tarLogFile files: %v %v\n", files, destfile)
-
-	if err := Compress(files, destfile); err != nil {
-		fmt.Fprintf(os.Stderr, "tarLogFile Compress:%s\n", err.Error())
-		return
-	}
-
-	//删除压缩过的文件
-	for _, file := range files {
-		os.Remove(file)
-	}
-	dd := dir + "backup" + string(os.PathSeparator)
-	cc, er := ioutil.ReadDir(dd)
-	if er != nil {
-		fmt.Fprintf(os.Stderr, "ReadDir %v\n", er)
-		return
-	}
-	nt := time.Now().Unix()
-	for _, ff := range cc {
-		if !ff.IsDir() {
-			if ff.ModTime().Unix()+int64(w.maxbackup*24*3600) < nt {
-				os.Remove(dd + ff.Name())
-			}
-		}
-	}
-	return
-}
-
-//压缩 使用gzip压缩成tar.gz
-func Compress(files []string, dest string) error {
-	_, err := os.Stat(dest)
-	if err == nil || os.IsExist(err) {
-		return errors.New("file have exist")
-	}
-
-	d, _ := os.Create(dest)
-	defer d.Close()
-	gw := gzip.NewWriter(d)
-	defer gw.Close()
-	tw := tar.NewWriter(gw)
-	defer tw.Close()
-	for _, file := range files {
-		// 打开要打包的文件，准备读取
-		fr, err := os.Open(file)
-		if err != nil {
-			return err
-		}
-		defer fr.Close()
-
-		err = compress(fr, "", tw)
-		if err != nil {
-			return err
-		}
-	}
-	return nil
+// SetBlockOnFull controls whether LogWrite blocks when the internal record
+// buffer is full (the default) or drops the record and increments the
+// counter returned by Dropped (chainable). Must be called before the first
+// log message is written.
+func (w *FileLogWriter) SetBlockOnFull(block bool) *FileLogWriter {
+	w.blockOnFull = block
+	return w
 }
 
-func compress(file *os.File, prefix string, tw *tar.Writer) error {
-	info, err := file.Stat()
-	if err != nil {
-		return err
-	}
-	if info.IsDir() {
-		prefix = prefix + "/" + info.Name()
-		fileInfos, err := file.Readdir(-1)
-		if err != nil {
-			return err
-		}
-		for _, fi := range fileInfos {
-			f, err := os.Open(file.Name() + "/" + fi.Name())
-			if err != nil {
-				return err
-			}
-			err = compress(f, prefix, tw)
-			if err != nil {
-				return err
-			}
-		}
-	} else {
-		header, err := tar.FileInfoHeader(info, "")
-		header.Name = prefix + "/" + header.Name
-		if err != nil {
-			return err
-		}
-		err = tw.WriteHeader(header)
-		if err != nil {
-			return err
-		}
-		_, err = io.Copy(tw, file)
-		file.Close()
-		if err != nil {
-			return err
-		}
-	}
-	return nil
+// SetRotationPolicy overrides the default composite of line/size/daily/
+// hourly/interval checks with a custom RotationPolicy (chainable). Must be
+// called before the first log message is written.
+func (w *FileLogWriter) SetRotationPolicy(p RotationPolicy) *FileLogWriter {
+	w.policy = p
+	return w
 }
 
 // NewXMLLogWriter is a utility method for creating a FileLogWriter set up to
@@ -370,3 +803,45 @@ func NewXMLLogWriter(fname string, rotate bool) *FileLogWriter {
 		<message>%M</message>
 	</record>`).SetHeadFoot("<log created=\"%D %T\">", "</log>")
 }
+
+// NewJSONLogWriter is a utility method for creating a FileLogWriter set up to
+// output one NDJSON (newline-delimited JSON) record per line instead of
+// line-based text, for log aggregators that expect structured records.
+//
+// Unlike the other New*LogWriter helpers, this one doesn't go through
+// FormatLogRecord: %-substitution into a hand-written template can't escape
+// quotes, backslashes or control characters out of Source/Message, so any
+// record containing them would produce invalid JSON. formatJSON instead
+// marshals the record (including any Fields attached via Logger.LogWithFields)
+// with encoding/json, which escapes correctly.
+func NewJSONLogWriter(fname string, rotate bool) *FileLogWriter {
+	w := NewFileLogWriter(fname, rotate)
+	w.useJSON = true
+	return w
+}
+
+// jsonRecord is the on-disk shape of a NewJSONLogWriter record.
+type jsonRecord struct {
+	Time    string                 `json:"time"`
+	Level   string                 `json:"level"`
+	Source  string                 `json:"source"`
+	Message string                 `json:"message"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+}
+
+// formatJSON renders rec as a single NDJSON line, properly escaping Source
+// and Message instead of splicing them into a template.
+func (w *FileLogWriter) formatJSON(rec *LogRecord) string {
+	b, err := json.Marshal(jsonRecord{
+		Time:    rec.Created.Format("2006-01-02 15:04:05"),
+		Level:   rec.Level.String(),
+		Source:  rec.Source,
+		Message: rec.Message,
+		Fields:  rec.Fields,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "FileLogWriter(%q): marshal json record: %s\n", w.filename, err.Error())
+		return ""
+	}
+	return string(b) + "\n"
+}
@@ -0,0 +1,96 @@
+// Copyright (C) 2010, Kyle Lemons <kyle@kylelemons.net>.  All rights reserved.
+
+package log4go
+
+import "time"
+
+// LogBufferLength is the number of log records that can be buffered in a
+// LogWriter's channel before LogWrite blocks (or, with SetBlockOnFull(false),
+// starts dropping records).
+const LogBufferLength = 32
+
+// Level identifies the severity of a LogRecord.
+type Level int
+
+const (
+	FINEST Level = iota
+	FINE
+	DEBUG
+	TRACE
+	INFO
+	WARNING
+	ERROR
+	CRITICAL
+)
+
+// String returns the canonical, upper-case name for the level.
+func (l Level) String() string {
+	switch l {
+	case FINEST:
+		return "FINEST"
+	case FINE:
+		return "FINE"
+	case DEBUG:
+		return "DEBUG"
+	case TRACE:
+		return "TRACE"
+	case INFO:
+		return "INFO"
+	case WARNING:
+		return "WARNING"
+	case ERROR:
+		return "ERROR"
+	case CRITICAL:
+		return "CRITICAL"
+	}
+	return "UNKNOWN"
+}
+
+// LogRecord is a single logging event, along with the context that produced
+// it.
+type LogRecord struct {
+	Level   Level     // The log level
+	Created time.Time // The time at which the log message was created
+	Source  string    // The message source
+	Message string    // The log message
+
+	// Fields carries optional structured key/value data attached to this
+	// record. Writers that understand structured output (NewJSONLogWriter)
+	// include it; writers that format a fixed text line ignore it.
+	Fields map[string]interface{}
+}
+
+// LogWriter is the interface a log output method must satisfy.
+type LogWriter interface {
+	LogWrite(rec *LogRecord)
+	Close()
+}
+
+// Logger routes LogRecords to a named set of LogWriters.
+type Logger map[string]LogWriter
+
+// Log builds a LogRecord from level, source and message and dispatches it to
+// every writer in the Logger.
+func (log Logger) Log(level Level, source, message string) {
+	log.log(level, source, message, nil)
+}
+
+// LogWithFields behaves like Log but attaches fields to the record so that
+// writers which support structured output (NewJSONLogWriter) can include
+// them.
+func (log Logger) LogWithFields(level Level, fields map[string]interface{}, message string) {
+	log.log(level, "", message, fields)
+}
+
+func (log Logger) log(level Level, source, message string, fields map[string]interface{}) {
+	rec := &LogRecord{
+		Level:   level,
+		Created: time.Now(),
+		Source:  source,
+		Message: message,
+		Fields:  fields,
+	}
+	for _, writer := range log {
+		writer.LogWrite(rec)
+	}
+}